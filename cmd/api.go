@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// listOptions captures the query parameters accepted by the /clients,
+// /resources, and /verbs endpoints.
+type listOptions struct {
+	labelSelector map[string]string
+	verb          string
+	resource      string
+	minRequests   int64
+	sortBy        string
+	limit         int
+	offset        int
+	format        string
+	window        time.Duration
+}
+
+const (
+	sortByRequests = "requests"
+	// sortByClient sorts ascending by the entity's own name: client name on
+	// /clients, resource/verb name on /resources and /verbs.
+	sortByClient = "client"
+
+	formatJSON = "json"
+	formatProm = "prom"
+)
+
+// parseListOptions reads filtering, sorting, and pagination parameters off
+// of r, applying the same defaults across every list endpoint.
+func parseListOptions(r *http.Request) (*listOptions, error) {
+	q := r.URL.Query()
+
+	opts := &listOptions{
+		labelSelector: map[string]string{},
+		verb:          q.Get("verb"),
+		resource:      q.Get("resource"),
+		sortBy:        sortByRequests,
+		limit:         -1,
+		format:        formatJSON,
+	}
+
+	if sel := q.Get("labelSelector"); len(sel) > 0 {
+		for _, pair := range strings.Split(sel, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("malformed labelSelector term %q: expected key=value", pair)
+			}
+			opts.labelSelector[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+
+	if min := q.Get("minRequests"); len(min) > 0 {
+		n, err := strconv.ParseInt(min, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid minRequests %q: %v", min, err)
+		}
+		opts.minRequests = n
+	}
+
+	if sortBy := q.Get("sortBy"); len(sortBy) > 0 {
+		if sortBy != sortByRequests && sortBy != sortByClient {
+			return nil, fmt.Errorf("invalid sortBy %q: must be %q or %q", sortBy, sortByRequests, sortByClient)
+		}
+		opts.sortBy = sortBy
+	}
+
+	if limit := q.Get("limit"); len(limit) > 0 {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid limit %q: must be a non-negative integer", limit)
+		}
+		opts.limit = n
+	}
+
+	if offset := q.Get("offset"); len(offset) > 0 {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid offset %q: must be a non-negative integer", offset)
+		}
+		opts.offset = n
+	}
+
+	if format := q.Get("format"); len(format) > 0 {
+		if format != formatJSON && format != formatProm {
+			return nil, fmt.Errorf("invalid format %q: must be %q or %q", format, formatJSON, formatProm)
+		}
+		opts.format = format
+	}
+
+	if window := q.Get("window"); len(window) > 0 {
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %v", window, err)
+		}
+		opts.window = d
+	}
+
+	if opts.window > 0 {
+		if err := validateWindowedOptions(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	return opts, nil
+}
+
+// validateWindowedOptions rejects filters that the snapshot store can't
+// honor. Snapshots are recorded per-client only (see snapshotStore), so a
+// ?window= query can only be sliced by client, not by verb/resource/other
+// labelSelector terms the way the unwindowed /clients listing can.
+func validateWindowedOptions(opts *listOptions) error {
+	if len(opts.verb) > 0 {
+		return fmt.Errorf("?verb= is not supported together with ?window=: historical snapshots are recorded per-client, not per-verb")
+	}
+	if len(opts.resource) > 0 {
+		return fmt.Errorf("?resource= is not supported together with ?window=: historical snapshots are recorded per-client, not per-resource")
+	}
+	for key := range opts.labelSelector {
+		if key != "client" {
+			return fmt.Errorf("labelSelector key %q is not supported together with ?window=: historical snapshots are recorded per-client only", key)
+		}
+	}
+	return nil
+}
+
+// filterAndSort applies opts to data, returning a new, independently
+// sorted/paginated list. data itself is left untouched.
+func filterAndSort(data ApiserverReqList, opts *listOptions) ApiserverReqList {
+	filtered := ApiserverReqList{}
+	for _, req := range data {
+		if !matchesListOptions(req, opts) {
+			continue
+		}
+		filtered = append(filtered, req)
+	}
+
+	if opts.sortBy == sortByClient {
+		sort.Slice(filtered, func(i, j int) bool { return filtered[i].ClientName < filtered[j].ClientName })
+	} else {
+		sort.Sort(filtered)
+	}
+
+	return paginate(filtered, opts)
+}
+
+func matchesListOptions(req *ApiserverReq, opts *listOptions) bool {
+	if req.TotalReqCount < opts.minRequests {
+		return false
+	}
+	if len(opts.verb) > 0 && !containsString(req.Verbs, opts.verb) {
+		return false
+	}
+	if len(opts.resource) > 0 && !containsString(req.Resources, opts.resource) {
+		return false
+	}
+	for key, val := range opts.labelSelector {
+		switch key {
+		case "verb":
+			if !containsString(req.Verbs, val) {
+				return false
+			}
+		case "resource":
+			if !containsString(req.Resources, val) {
+				return false
+			}
+		case "client":
+			if req.ClientName != val {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func paginate(data ApiserverReqList, opts *listOptions) ApiserverReqList {
+	if opts.offset >= len(data) {
+		return ApiserverReqList{}
+	}
+	data = data[opts.offset:]
+	if opts.limit >= 0 && opts.limit < len(data) {
+		data = data[:opts.limit]
+	}
+	return data
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// quantifiedData scrapes and quantifies metrics according to h's
+// configuration. It's the shared entry point for every JSON/text endpoint.
+func (h *quantReqHandler) quantifiedData() (ApiserverReqList, error) {
+	data, err := h.fetchMetrics()
+	if err != nil {
+		return nil, err
+	}
+	return quantify(data, h.metricName)
+}
+
+// Router wires the REST API endpoints on top of h, alongside the legacy
+// plain-text "/" dump for backwards compatibility.
+func (h *quantReqHandler) Router() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/", h.ServeHTTP).Methods(http.MethodGet)
+	router.HandleFunc("/clients", h.handleClients).Methods(http.MethodGet)
+	router.HandleFunc("/clients/{name}", h.handleClient).Methods(http.MethodGet)
+	router.HandleFunc("/resources", h.handleResources).Methods(http.MethodGet)
+	router.HandleFunc("/verbs", h.handleVerbs).Methods(http.MethodGet)
+	router.HandleFunc("/top", h.handleTop).Methods(http.MethodGet)
+	router.HandleFunc("/healthz", h.handleHealthz).Methods(http.MethodGet)
+	return router
+}
+
+const (
+	defaultTopWindow = 15 * time.Minute
+	defaultTopN      = 10
+)
+
+// handleTop answers "who is hammering my apiserver right now": the top-N
+// clients by request rate over the given window.
+func (h *quantReqHandler) handleTop(w http.ResponseWriter, r *http.Request) {
+	if h.store == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("historical storage is disabled; restart with --scrape-interval > 0"))
+		return
+	}
+
+	q := r.URL.Query()
+
+	window := defaultTopWindow
+	if raw := q.Get("window"); len(raw) > 0 {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid window %q: %v", raw, err))
+			return
+		}
+		window = d
+	}
+
+	n := defaultTopN
+	if raw := q.Get("n"); len(raw) > 0 {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid n %q: must be a positive integer", raw))
+			return
+		}
+		n = parsed
+	}
+
+	rates, err := h.store.rates(time.Now().Add(-window))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].RatePerSec > rates[j].RatePerSec })
+	if n < len(rates) {
+		rates = rates[:n]
+	}
+
+	writeJSON(w, rates)
+}
+
+// handleHealthz backs k8s liveness/readiness probes.
+func (h *quantReqHandler) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintln(w, "ok")
+}
+
+func (h *quantReqHandler) handleClients(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if opts.window > 0 {
+		h.writeRates(w, opts)
+		return
+	}
+
+	data, err := h.quantifiedData()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	filtered := filterAndSort(data, opts)
+	if opts.format == formatProm {
+		writePromClients(w, filtered)
+		return
+	}
+	writeJSON(w, filtered)
+}
+
+// writeRates serves the ?window= form of /clients: rather than the absolute
+// counter values in the latest scrape, it returns each client's request-rate
+// delta across its snapshots in [now-window, now].
+func (h *quantReqHandler) writeRates(w http.ResponseWriter, opts *listOptions) {
+	if h.store == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("historical storage is disabled; restart with --scrape-interval > 0"))
+		return
+	}
+
+	rates, err := h.store.rates(time.Now().Add(-opts.window))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	rates = filterRates(rates, opts)
+	if opts.sortBy == sortByClient {
+		sort.Slice(rates, func(i, j int) bool { return rates[i].Client < rates[j].Client })
+	} else {
+		sort.Slice(rates, func(i, j int) bool { return rates[i].RatePerSec > rates[j].RatePerSec })
+	}
+	writeJSON(w, paginateRates(rates, opts))
+}
+
+func filterRates(rates []*clientRate, opts *listOptions) []*clientRate {
+	filtered := []*clientRate{}
+	for _, rate := range rates {
+		if rate.DeltaTotal < opts.minRequests {
+			continue
+		}
+		if client, ok := opts.labelSelector["client"]; ok && rate.Client != client {
+			continue
+		}
+		filtered = append(filtered, rate)
+	}
+	return filtered
+}
+
+func paginateRates(rates []*clientRate, opts *listOptions) []*clientRate {
+	if opts.offset >= len(rates) {
+		return []*clientRate{}
+	}
+	rates = rates[opts.offset:]
+	if opts.limit >= 0 && opts.limit < len(rates) {
+		rates = rates[:opts.limit]
+	}
+	return rates
+}
+
+func (h *quantReqHandler) handleClient(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	data, err := h.quantifiedData()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, req := range data {
+		if req.ClientName == name {
+			writeJSON(w, req)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("no client named %q found", name))
+}
+
+// nameCount is the summary shape returned by /resources and /verbs: how many
+// requests were observed against a given resource or verb.
+type nameCount struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"totalRequests"`
+}
+
+func (h *quantReqHandler) handleResources(w http.ResponseWriter, r *http.Request) {
+	h.handleNameCounts(w, r, func(req *ApiserverReq) []string { return req.Resources })
+}
+
+func (h *quantReqHandler) handleVerbs(w http.ResponseWriter, r *http.Request) {
+	h.handleNameCounts(w, r, func(req *ApiserverReq) []string { return req.Verbs })
+}
+
+func (h *quantReqHandler) handleNameCounts(w http.ResponseWriter, r *http.Request, namesFor func(*ApiserverReq) []string) {
+	opts, err := parseListOptions(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	data, err := h.quantifiedData()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	counts := map[string]int64{}
+	for _, req := range filterAndSort(data, &listOptions{sortBy: opts.sortBy, limit: -1, labelSelector: opts.labelSelector, verb: opts.verb, resource: opts.resource, minRequests: opts.minRequests}) {
+		for _, name := range namesFor(req) {
+			counts[name] += req.TotalReqCount
+		}
+	}
+
+	results := make([]*nameCount, 0, len(counts))
+	for name, count := range counts {
+		results = append(results, &nameCount{Name: name, Requests: count})
+	}
+	if opts.sortBy == sortByClient {
+		sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	} else {
+		sort.Slice(results, func(i, j int) bool { return results[i].Requests > results[j].Requests })
+	}
+
+	paged := results
+	if opts.offset < len(paged) {
+		paged = paged[opts.offset:]
+	} else {
+		paged = nil
+	}
+	if opts.limit >= 0 && opts.limit < len(paged) {
+		paged = paged[:opts.limit]
+	}
+
+	writeJSON(w, paged)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	writeJSON(w, map[string]string{"error": err.Error()})
+}
+
+// writePromClients re-emits a client list as Prometheus text, so it can be
+// scraped by a Prometheus server and charted (e.g. in Grafana) alongside the
+// apiserver's own metrics.
+func writePromClients(w http.ResponseWriter, data ApiserverReqList) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP apiserver_quantified_request_total Aggregated apiserver requests observed by apiserver_req_quantifier.")
+	fmt.Fprintln(w, "# TYPE apiserver_quantified_request_total counter")
+	for _, req := range data {
+		fmt.Fprintf(w, "apiserver_quantified_request_total{client=%q,resources=%q,verbs=%q} %d\n",
+			req.ClientName, strings.Join(req.Resources, ","), strings.Join(req.Verbs, ","), req.TotalReqCount)
+	}
+}
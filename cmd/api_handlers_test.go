@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestHandler returns a quantReqHandler that scrapes metricsText from a
+// local httptest server, so handler tests exercise real HTTP + JSON
+// round-trips without touching a live apiserver.
+func newTestHandler(t *testing.T, metricsText string) *quantReqHandler {
+	t.Helper()
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(metricsText))
+	}))
+	t.Cleanup(metricsServer.Close)
+
+	return &quantReqHandler{
+		metricsSource: metricsSourceURL,
+		metricsURL:    metricsServer.URL,
+		metricName:    defaultMetricName,
+	}
+}
+
+const handlerTestMetrics = `# HELP apiserver_request_total test counter
+# TYPE apiserver_request_total counter
+apiserver_request_total{client="c1",resource="pods",verb="get",code="200"} 5
+apiserver_request_total{client="c1",resource="pods",verb="list",code="404"} 2
+`
+
+// TestHandleClientsServesJSON guards against ApiserverReq's fields silently
+// dropping out of the response: it makes a real HTTP request through
+// Router() and decodes the actual response body, rather than calling
+// filterAndSort/matchesListOptions directly.
+func TestHandleClientsServesJSON(t *testing.T) {
+	handler := newTestHandler(t, handlerTestMetrics)
+
+	req := httptest.NewRequest(http.MethodGet, "/clients", nil)
+	rec := httptest.NewRecorder()
+	handler.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body = %s", rec.Code, rec.Body.String())
+	}
+
+	var got []ApiserverReq
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding /clients response: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("len(got) = %d, want 1; body = %s", len(got), rec.Body.String())
+	}
+
+	c1 := got[0]
+	if c1.ClientName != "c1" {
+		t.Errorf("ClientName = %q, want c1", c1.ClientName)
+	}
+	if c1.TotalReqCount != 7 {
+		t.Errorf("TotalReqCount = %d, want 7", c1.TotalReqCount)
+	}
+	if c1.Codes["2xx"] != 5 || c1.Codes["4xx"] != 2 {
+		t.Errorf("Codes = %v, want 2xx=5, 4xx=2", c1.Codes)
+	}
+}
+
+// TestHandleClientServesJSON is the /clients/{name} counterpart to
+// TestHandleClientsServesJSON.
+func TestHandleClientServesJSON(t *testing.T) {
+	handler := newTestHandler(t, handlerTestMetrics)
+
+	req := httptest.NewRequest(http.MethodGet, "/clients/c1", nil)
+	rec := httptest.NewRecorder()
+	handler.Router().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body = %s", rec.Code, rec.Body.String())
+	}
+
+	var got ApiserverReq
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding /clients/c1 response: %v", err)
+	}
+	if got.ClientName != "c1" {
+		t.Errorf("ClientName = %q, want c1", got.ClientName)
+	}
+	if got.TotalReqCount != 7 {
+		t.Errorf("TotalReqCount = %d, want 7", got.TotalReqCount)
+	}
+}
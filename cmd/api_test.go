@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func sampleReqs() ApiserverReqList {
+	return ApiserverReqList{
+		{ClientName: "alpha", TotalReqCount: 10, Resources: []string{"pods"}, Verbs: []string{"get"}},
+		{ClientName: "beta", TotalReqCount: 30, Resources: []string{"nodes"}, Verbs: []string{"list"}},
+		{ClientName: "gamma", TotalReqCount: 20, Resources: []string{"pods"}, Verbs: []string{"list"}},
+	}
+}
+
+func TestFilterAndSortDefaultsToRequestsDescending(t *testing.T) {
+	got := filterAndSort(sampleReqs(), &listOptions{sortBy: sortByRequests, limit: -1})
+	want := []string{"beta", "gamma", "alpha"}
+	assertClientOrder(t, got, want)
+}
+
+func TestFilterAndSortByClientName(t *testing.T) {
+	got := filterAndSort(sampleReqs(), &listOptions{sortBy: sortByClient, limit: -1})
+	want := []string{"alpha", "beta", "gamma"}
+	assertClientOrder(t, got, want)
+}
+
+func TestFilterAndSortByResourceAndMinRequests(t *testing.T) {
+	got := filterAndSort(sampleReqs(), &listOptions{sortBy: sortByRequests, limit: -1, resource: "pods", minRequests: 15})
+	want := []string{"gamma"}
+	assertClientOrder(t, got, want)
+}
+
+func TestFilterAndSortPagination(t *testing.T) {
+	got := filterAndSort(sampleReqs(), &listOptions{sortBy: sortByRequests, limit: 1, offset: 1})
+	want := []string{"gamma"}
+	assertClientOrder(t, got, want)
+}
+
+func TestMatchesListOptionsLabelSelector(t *testing.T) {
+	req := &ApiserverReq{ClientName: "alpha", Resources: []string{"pods"}, Verbs: []string{"get"}}
+
+	opts := &listOptions{labelSelector: map[string]string{"resource": "pods", "verb": "get"}}
+	if !matchesListOptions(req, opts) {
+		t.Error("expected req to match labelSelector resource=pods,verb=get")
+	}
+
+	opts = &listOptions{labelSelector: map[string]string{"resource": "nodes"}}
+	if matchesListOptions(req, opts) {
+		t.Error("expected req not to match labelSelector resource=nodes")
+	}
+
+	opts = &listOptions{labelSelector: map[string]string{"bogus": "x"}}
+	if matchesListOptions(req, opts) {
+		t.Error("expected req not to match an unknown labelSelector key")
+	}
+}
+
+func TestValidateWindowedOptionsRejectsVerbAndResource(t *testing.T) {
+	cases := []*listOptions{
+		{window: 1, verb: "get"},
+		{window: 1, resource: "pods"},
+		{window: 1, labelSelector: map[string]string{"verb": "get"}},
+	}
+	for _, opts := range cases {
+		if err := validateWindowedOptions(opts); err == nil {
+			t.Errorf("validateWindowedOptions(%+v) = nil, want an error", opts)
+		}
+	}
+}
+
+func TestValidateWindowedOptionsAllowsClientSelector(t *testing.T) {
+	opts := &listOptions{window: 1, labelSelector: map[string]string{"client": "alpha"}}
+	if err := validateWindowedOptions(opts); err != nil {
+		t.Errorf("validateWindowedOptions with only a client selector: %v", err)
+	}
+}
+
+func assertClientOrder(t *testing.T, got ApiserverReqList, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i, name := range want {
+		if got[i].ClientName != name {
+			t.Errorf("result[%d].ClientName = %q, want %q", i, got[i].ClientName, name)
+		}
+	}
+}
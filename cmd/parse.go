@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// defaultMetricName is the counter apiserver_request_count was renamed to
+	// in Kubernetes 1.14+.
+	defaultMetricName = "apiserver_request_total"
+	// legacyMetricName is scraped when defaultMetricName isn't present, for
+	// pre-1.14 apiservers.
+	legacyMetricName = "apiserver_request_count"
+)
+
+// ApiserverReq aggregates request counts for a single client, broken down by
+// the resources/verbs it hit and by response code class (2xx/4xx/5xx/...).
+// Fields are exported and tagged so handlers can hand one straight to
+// encoding/json without a separate DTO.
+type ApiserverReq struct {
+	ClientName    string           `json:"client"`
+	TotalReqCount int64            `json:"totalRequests"`
+	Resources     []string         `json:"resources"`
+	Verbs         []string         `json:"verbs"`
+	Codes         map[string]int64 `json:"codes"`
+}
+
+func (r *ApiserverReq) String() string {
+	s := fmt.Sprintf("[ %s ]\n", r.ClientName)
+	s += fmt.Sprintf("  - Total Requests: %v\n", r.TotalReqCount)
+	s += fmt.Sprintf("  - Resources: %v\n", r.Resources)
+	s += fmt.Sprintf("  - Verbs: %v\n", r.Verbs)
+	s += fmt.Sprintf("  - Codes: %v\n", r.Codes)
+	return s
+}
+
+type ApiserverReqList []*ApiserverReq
+
+func (l ApiserverReqList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+func (l ApiserverReqList) Less(i, j int) bool {
+	return l[i].TotalReqCount > l[j].TotalReqCount
+}
+
+func (l ApiserverReqList) Len() int {
+	return len(l)
+}
+
+// quantify parses raw Prometheus text data and aggregates the named metric
+// (falling back to legacyMetricName) by client. It understands counter,
+// histogram, and summary metric types, using the sample count as the request
+// total for the latter two.
+func quantify(data []byte, metricName string) (ApiserverReqList, error) {
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse prometheus metrics: %v", err)
+	}
+
+	family, ok := families[metricName]
+	if !ok {
+		family, ok = families[legacyMetricName]
+		if !ok {
+			return nil, fmt.Errorf("neither %q nor %q found in scraped metrics", metricName, legacyMetricName)
+		}
+	}
+
+	reqs := map[string]*ApiserverReq{}
+	for _, metric := range family.GetMetric() {
+		req := apiserverReqFromMetric(family.GetType(), metric)
+		if req == nil {
+			continue
+		}
+
+		if seenReq, ok := reqs[req.ClientName]; ok {
+			seenReq.TotalReqCount += req.TotalReqCount
+			seenReq.Verbs = append(seenReq.Verbs, req.Verbs...)
+			seenReq.Resources = append(seenReq.Resources, req.Resources...)
+			for code, count := range req.Codes {
+				seenReq.Codes[code] += count
+			}
+			continue
+		}
+		reqs[req.ClientName] = req
+	}
+
+	reqsList := ApiserverReqList{}
+	for _, v := range reqs {
+		reqsList = append(reqsList, v)
+	}
+	return reqsList, nil
+}
+
+// apiserverReqFromMetric extracts an ApiserverReq from a single Prometheus
+// sample, reading its count from the field appropriate to the metric's type.
+func apiserverReqFromMetric(metricType dto.MetricType, metric *dto.Metric) *ApiserverReq {
+	var count int64
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		count = int64(metric.GetCounter().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		count = int64(metric.GetHistogram().GetSampleCount())
+	case dto.MetricType_SUMMARY:
+		count = int64(metric.GetSummary().GetSampleCount())
+	default:
+		return nil
+	}
+
+	req := &ApiserverReq{TotalReqCount: count, Codes: map[string]int64{}}
+	for _, label := range metric.GetLabel() {
+		switch label.GetName() {
+		case "client":
+			req.ClientName = label.GetValue()
+		case "resource":
+			req.Resources = []string{label.GetValue()}
+		case "verb":
+			req.Verbs = []string{label.GetValue()}
+		case "code":
+			req.Codes[codeClass(label.GetValue())] += count
+		}
+	}
+	if len(req.ClientName) == 0 {
+		req.ClientName = "unknown"
+	}
+
+	return req
+}
+
+// codeClass buckets an HTTP status code string into its class, e.g. "404"
+// becomes "4xx". Unparseable codes are reported as "unknown".
+func codeClass(code string) string {
+	if len(code) == 0 {
+		return "unknown"
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return "unknown"
+		}
+	}
+	return string(code[0]) + "xx"
+}
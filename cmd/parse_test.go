@@ -0,0 +1,102 @@
+package main
+
+import "testing"
+
+func TestQuantifyCounterByCodeClass(t *testing.T) {
+	data := []byte(`# HELP apiserver_request_total test counter
+# TYPE apiserver_request_total counter
+apiserver_request_total{client="c1",resource="pods",verb="get",code="200"} 5
+apiserver_request_total{client="c1",resource="pods",verb="list",code="404"} 2
+apiserver_request_total{client="c2",resource="nodes",verb="get",code="500"} 1
+`)
+
+	reqs, err := quantify(data, defaultMetricName)
+	if err != nil {
+		t.Fatalf("quantify: %v", err)
+	}
+
+	byClient := map[string]*ApiserverReq{}
+	for _, req := range reqs {
+		byClient[req.ClientName] = req
+	}
+
+	c1, ok := byClient["c1"]
+	if !ok {
+		t.Fatalf("expected client c1 in result, got %v", byClient)
+	}
+	if c1.TotalReqCount != 7 {
+		t.Errorf("c1.TotalReqCount = %d, want 7", c1.TotalReqCount)
+	}
+	if c1.Codes["2xx"] != 5 || c1.Codes["4xx"] != 2 {
+		t.Errorf("c1.Codes = %v, want 2xx=5, 4xx=2", c1.Codes)
+	}
+
+	c2, ok := byClient["c2"]
+	if !ok {
+		t.Fatalf("expected client c2 in result, got %v", byClient)
+	}
+	if c2.TotalReqCount != 1 || c2.Codes["5xx"] != 1 {
+		t.Errorf("c2 = %+v, want totalReqCount=1, codes[5xx]=1", c2)
+	}
+}
+
+func TestQuantifyHistogramUsesSampleCount(t *testing.T) {
+	data := []byte(`# HELP apiserver_request_duration test histogram
+# TYPE apiserver_request_duration histogram
+apiserver_request_duration_bucket{client="c1",le="0.1"} 3
+apiserver_request_duration_bucket{client="c1",le="+Inf"} 5
+apiserver_request_duration_sum{client="c1"} 1.2
+apiserver_request_duration_count{client="c1"} 5
+`)
+
+	reqs, err := quantify(data, "apiserver_request_duration")
+	if err != nil {
+		t.Fatalf("quantify: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("len(reqs) = %d, want 1", len(reqs))
+	}
+	if reqs[0].TotalReqCount != 5 {
+		t.Errorf("totalReqCount = %d, want 5 (the sample count)", reqs[0].TotalReqCount)
+	}
+}
+
+func TestQuantifyFallsBackToLegacyMetricName(t *testing.T) {
+	data := []byte(`# HELP apiserver_request_count test counter
+# TYPE apiserver_request_count counter
+apiserver_request_count{client="c1",resource="pods",verb="get"} 3
+`)
+
+	reqs, err := quantify(data, defaultMetricName)
+	if err != nil {
+		t.Fatalf("quantify: %v", err)
+	}
+	if len(reqs) != 1 || reqs[0].ClientName != "c1" || reqs[0].TotalReqCount != 3 {
+		t.Errorf("reqs = %+v, want single c1 entry with totalReqCount=3", reqs)
+	}
+}
+
+func TestQuantifyErrorsWhenMetricMissingEntirely(t *testing.T) {
+	data := []byte(`# HELP unrelated_metric test counter
+# TYPE unrelated_metric counter
+unrelated_metric{client="c1"} 3
+`)
+
+	if _, err := quantify(data, defaultMetricName); err == nil {
+		t.Fatal("expected an error when neither metric name is present, got nil")
+	}
+}
+
+func TestCodeClass(t *testing.T) {
+	cases := map[string]string{
+		"200": "2xx",
+		"404": "4xx",
+		"500": "5xx",
+		"":    "unknown",
+	}
+	for code, want := range cases {
+		if got := codeClass(code); got != want {
+			t.Errorf("codeClass(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
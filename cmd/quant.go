@@ -1,19 +1,21 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
-	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -22,29 +24,17 @@ const (
 
 	metricsAddr = "localhost"
 	metricsPort = "8080"
-)
-
-type kubeconfig struct {
-	CurrentContext string               `yaml:"current-context"`
-	Contexts       []*kubeconfigContext `yaml:"contexts"`
-	Clusters       []*kubeconfigCluster `yaml:"clusters"`
-}
-
-type kubeconfigCluster struct {
-	Cluster *kubeconfigClusterInfo `yaml:"cluster"`
-	Name    string                 `yaml:"name"`
-}
-type kubeconfigClusterInfo struct {
-	Server string `yaml:"server"`
-}
 
-type kubeconfigContext struct {
-	Context *kubeconfigContextInfo
-	Name    string `yaml:"name"`
-}
-type kubeconfigContextInfo struct {
-	Cluster string `yaml:"cluster"`
-}
+	// metricsSourceLocal scrapes a sidecar/local proxy at metricsAddr:metricsPort,
+	// the historical behavior of this tool.
+	metricsSourceLocal = "local"
+	// metricsSourceAPIServer scrapes the /metrics endpoint of the apiserver
+	// identified by the resolved rest.Config, authenticating with its
+	// ServiceAccount bearer token and CA bundle.
+	metricsSourceAPIServer = "apiserver"
+	// metricsSourceURL scrapes an arbitrary, user-provided URL.
+	metricsSourceURL = "url"
+)
 
 type uptimeResult struct {
 	err    error
@@ -52,33 +42,35 @@ type uptimeResult struct {
 }
 
 type quantReqHandler struct {
-	kubeconfig string
+	restConfig    *rest.Config
+	metricsSource string
+	metricsURL    string
+	metricName    string
+	store         *snapshotStore
 }
 
 func (h *quantReqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// obtain master node uptime
 	uptimeResultChan := make(chan *uptimeResult)
-	go uptimeAsync(uptimeResultChan, h.kubeconfig)
+	go uptimeAsync(uptimeResultChan, h.restConfig)
 
-	res, err := http.Get(fmt.Sprintf("http://%s:%s/metrics", metricsAddr, metricsPort))
+	data, err := h.fetchMetrics()
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "error: %v\n", err)
 		return
 	}
 
-	data, err := ioutil.ReadAll(res.Body)
+	quantifiedData, err := quantify(data, h.metricName)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "error: %v\n", err)
 		return
 	}
+	sort.Sort(quantifiedData)
 
 	w.WriteHeader(http.StatusPartialContent)
 
-	quantifiedData := quantify(data)
-	sort.Sort(quantifiedData)
-
 	fmt.Fprintf(w, "[ %q ]\n", "INFO(not metrics): Master node uptime")
 
 	// prepend uptime data (if any) to beginning of output
@@ -102,227 +94,241 @@ func (h *quantReqHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// uptimeAsync is meant to be called on a goroutine
-// attempts to ssh into the master node and uptime system uptime information
-func uptimeAsync(result chan *uptimeResult, configPath string) {
-	configData, err := ioutil.ReadFile(configPath)
+// fetchMetrics retrieves raw Prometheus text data according to h.metricsSource.
+func (h *quantReqHandler) fetchMetrics() ([]byte, error) {
+	switch h.metricsSource {
+	case metricsSourceAPIServer:
+		return h.fetchAPIServerMetrics()
+	case metricsSourceURL:
+		return fetchURLMetrics(h.metricsURL)
+	default:
+		return fetchURLMetrics(fmt.Sprintf("http://%s:%s/metrics", metricsAddr, metricsPort))
+	}
+}
+
+// fetchAPIServerMetrics scrapes /metrics directly on the apiserver identified
+// by h.restConfig, reusing its TLS transport (CA bundle + ServiceAccount
+// bearer token or client certificate) so no sidecar or kubectl proxy is
+// required.
+func (h *quantReqHandler) fetchAPIServerMetrics() ([]byte, error) {
+	if h.restConfig == nil {
+		return nil, fmt.Errorf("no rest.Config available to reach the apiserver")
+	}
+
+	client, err := rest.HTTPClientFor(h.restConfig)
 	if err != nil {
-		result <- &uptimeResult{err: err}
-		return
+		return nil, fmt.Errorf("unable to build apiserver client: %v", err)
 	}
 
-	config := &kubeconfig{}
-	if err := yaml.Unmarshal(configData, config); err != nil {
-		result <- &uptimeResult{err: fmt.Errorf("error: unable to unmarshal provided KUBECONFIG: %v", err)}
-		return
+	metricsURL := strings.TrimSuffix(h.restConfig.Host, "/") + "/metrics"
+	res, err := client.Get(metricsURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to scrape %s: %v", metricsURL, err)
 	}
+	defer res.Body.Close()
 
-	if len(config.CurrentContext) == 0 {
-		result <- &uptimeResult{err: fmt.Errorf("invalid kubeconfig: empty current-context field")}
-		return
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s scraping %s", res.Status, metricsURL)
 	}
-	if len(config.Contexts) == 0 {
-		result <- &uptimeResult{err: fmt.Errorf("invalid kubeconfig: no contexts found")}
-		return
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// fetchURLMetrics scrapes raw Prometheus text data from an arbitrary URL.
+func fetchURLMetrics(url string) ([]byte, error) {
+	res, err := http.Get(url)
+	if err != nil {
+		return nil, err
 	}
-	if len(config.Clusters) == 0 {
-		result <- &uptimeResult{err: fmt.Errorf("invalid kubeconfig: no clusters found")}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// runScraper periodically quantifies the current metrics scrape and records
+// it to h.store, so that windowed rate/delta queries have history to work
+// with. It blocks until stop is closed, so callers should run it on its own
+// goroutine.
+func (h *quantReqHandler) runScraper(interval time.Duration, stop <-chan struct{}) {
+	if h.store == nil || interval <= 0 {
 		return
 	}
 
-	var context *kubeconfigContext
-	for _, ctx := range config.Contexts {
-		if ctx.Name == config.CurrentContext {
-			context = ctx
-			break
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			data, err := h.quantifiedData()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: periodic scrape failed: %v\n", err)
+				continue
+			}
+			if err := h.store.record(time.Now(), data); err != nil {
+				fmt.Fprintf(os.Stderr, "error: unable to record snapshot: %v\n", err)
+			}
+		case <-stop:
+			return
 		}
 	}
-	if context == nil {
-		result <- &uptimeResult{err: fmt.Errorf("invalid kubeconfig: unable to find current context (%s) in provided list of contexts", config.CurrentContext)}
-		return
-	}
+}
 
-	clusterName := ""
-	for _, cluster := range config.Clusters {
-		if cluster.Name == context.Context.Cluster {
-			clusterName = cluster.Cluster.Server
-			break
+// loadRESTConfig resolves a *rest.Config for talking to the apiserver.
+// When kubeconfigPath is non-empty, it is loaded from disk via clientcmd. When
+// it is empty, we assume we're running inside a pod and fall back to
+// rest.InClusterConfig(), which reads the ServiceAccount token and CA bundle
+// mounted at /var/run/secrets/kubernetes.io/serviceaccount.
+func loadRESTConfig(kubeconfigPath string) (*rest.Config, error) {
+	if len(kubeconfigPath) == 0 {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("no --kubeconfig provided and unable to load in-cluster config: %v", err)
 		}
+		return config, nil
 	}
-	if len(clusterName) == 0 {
-		result <- &uptimeResult{err: fmt.Errorf("invalid kubeconfig: unable to find current cluster (%s) in provided list of clusters", context.Context.Cluster)}
-		return
+
+	apiConfig, err := clientcmd.LoadFromFile(kubeconfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig %q: %v", kubeconfigPath, err)
 	}
 
-	hostSegs := strings.Split(clusterName, "://")
-	if len(hostSegs) < 2 {
-		result <- &uptimeResult{err: fmt.Errorf("malformed cluster hostname: expecting http(s)://host.name:port format, but got %s", clusterName)}
+	clientConfig := clientcmd.NewDefaultClientConfig(*apiConfig, &clientcmd.ConfigOverrides{})
+	return clientConfig.ClientConfig()
+}
+
+// masterNodeLabels are the well-known node-role labels used to identify
+// master/control-plane nodes across Kubernetes versions.
+var masterNodeLabels = []string{
+	"node-role.kubernetes.io/master",
+	"node-role.kubernetes.io/control-plane",
+}
+
+// uptimeAsync is meant to be called on a goroutine.
+// It queries the Node API for master/control-plane nodes and reports their
+// uptime (derived from CreationTimestamp) and readiness.
+func uptimeAsync(result chan *uptimeResult, config *rest.Config) {
+	if config == nil {
+		result <- &uptimeResult{err: fmt.Errorf("no rest.Config available")}
 		return
 	}
-	hostSegs = strings.Split(hostSegs[1], ":")
-	if len(hostSegs) == 0 {
-		result <- &uptimeResult{err: fmt.Errorf("malformed cluster hostname: expecting http(s)://host.name:port format, but got %s", clusterName)}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		result <- &uptimeResult{err: fmt.Errorf("unable to build Kubernetes client: %v", err)}
 		return
 	}
-	hostName := hostSegs[0]
-	username := "core"
-	stdout := bytes.NewBuffer(nil)
-	stderr := bytes.NewBuffer(nil)
-
-	cmd := exec.Command("/usr/bin/ssh", "-o", "UserKnownHostsFile=/dev/null", "-o", "StrictHostKeyChecking=no", fmt.Sprintf("%s@%s", username, hostName), "uptime", "--pretty")
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		result <- &uptimeResult{err: fmt.Errorf("ssh error: %v: %v", err, stderr.String())}
+
+	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		result <- &uptimeResult{err: fmt.Errorf("unable to list nodes: %v", err)}
 		return
 	}
-	if len(stdout.String()) > 0 {
-		result <- &uptimeResult{result: stdout.String()}
-		return
+
+	var masters []corev1.Node
+	for _, node := range nodes.Items {
+		for _, label := range masterNodeLabels {
+			if _, ok := node.Labels[label]; ok {
+				masters = append(masters, node)
+				break
+			}
+		}
 	}
-	if len(stderr.String()) > 0 {
-		result <- &uptimeResult{err: fmt.Errorf("error: stderr: %s", stderr.String())}
+	if len(masters) == 0 {
+		result <- &uptimeResult{err: fmt.Errorf("no nodes found with labels %v", masterNodeLabels)}
 		return
 	}
-	result <- &uptimeResult{err: fmt.Errorf("error: no output from command: %s", cmd.Args)}
-}
 
-type ApiserverReq struct {
-	clientName    string
-	totalReqCount int64
-	resources     []string
-	verbs         []string
-}
-
-func (r *ApiserverReq) String() string {
-	s := fmt.Sprintf("[ %s ]\n", r.clientName)
-	s += fmt.Sprintf("  - Total Requests: %v\n", r.totalReqCount)
-	s += fmt.Sprintf("  - Resources: %v\n", r.resources)
-	s += fmt.Sprintf("  - Verbs: %v\n", r.verbs)
-	return s
-}
-
-type ApiserverReqList []*ApiserverReq
-
-func (l ApiserverReqList) Swap(i, j int) {
-	l[i], l[j] = l[j], l[i]
-}
-
-func (l ApiserverReqList) Less(i, j int) bool {
-	return l[i].totalReqCount > l[j].totalReqCount
-}
-
-func (l ApiserverReqList) Len() int {
-	return len(l)
-}
-
-// quantify receives prometheus metrics data as an array of bytes
-// and measures apiserver_request_count
-func quantify(data []byte) ApiserverReqList {
-	// store total number of requests by client names
-	reqs := map[string]*ApiserverReq{}
-	shouldRecord := false
-	idx := 0
-	for _, line := range strings.Split(string(data), "\n") {
-		idx++
-		if strings.HasPrefix(line, "# TYPE") {
-			continue
-		}
-		if strings.HasPrefix(line, "# HELP") {
-			if shouldRecord {
-				// if we have been recording, seeing this prefix means that
-				// we have started a new section. No sense in continuing to record.
+	now := time.Now()
+	notReady := 0
+	lines := make([]string, 0, len(masters))
+	for _, node := range masters {
+		ready := false
+		readySince := now
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == corev1.NodeReady {
+				ready = cond.Status == corev1.ConditionTrue
+				readySince = cond.LastTransitionTime.Time
 				break
 			}
-			shouldRecord = strings.HasPrefix(line, "# HELP apiserver_request_count")
-			continue
 		}
-		if !shouldRecord {
-			continue
-		}
-
-		req, err := parseLine(line)
-		if req == nil || len(req.clientName) == 0 {
-			fmt.Fprintf(os.Stderr, "error: malformed metrics line: %v: %v\n", line, err)
-			continue
+		if !ready {
+			notReady++
 		}
 
-		if seenReq, ok := reqs[req.clientName]; ok {
-			seenReq.totalReqCount += req.totalReqCount
-			seenReq.verbs = append(seenReq.verbs, req.verbs...)
-			seenReq.resources = append(seenReq.resources, req.resources...)
-			continue
+		state := "Ready"
+		if !ready {
+			state = "NotReady"
 		}
-		reqs[req.clientName] = req
+		lines = append(lines, fmt.Sprintf("%s: %s, up %s (%s since %s)",
+			node.Name, state, now.Sub(node.CreationTimestamp.Time).Round(time.Second), state, readySince.Format(time.RFC3339)))
 	}
+	lines = append(lines, fmt.Sprintf("NotReady master nodes: %d/%d", notReady, len(masters)))
 
-	reqsList := []*ApiserverReq{}
-	for _, v := range reqs {
-		reqsList = append(reqsList, v)
-	}
-	return reqsList
+	result <- &uptimeResult{result: strings.Join(lines, "\n  - ")}
 }
 
-func parseLine(line string) (*ApiserverReq, error) {
-	req := &ApiserverReq{}
-	objEnd := strings.Split(line, "}")
-	if len(objEnd) == 0 {
-		return nil, fmt.Errorf("missing metrics object delimiter '}'")
-	}
-	if len(objEnd) >= 2 {
-		count, err := strconv.ParseInt(strings.TrimSpace(objEnd[1]), 10, 32)
-		if err != nil {
-			return nil, err
-		}
-		req.totalReqCount = count
+func main() {
+	var kubeconfigPath, metricsSource, metricsURL, metricName, storePath string
+	var scrapeInterval time.Duration
+	flag.StringVar(&kubeconfigPath, "kubeconfig", "", "Absolute path to the kubeconfig generated by the OpenShift installer. If empty, $KUBECONFIG is used; if that is also empty, an in-cluster config is assumed.")
+	flag.StringVar(&metricsSource, "metrics-source", metricsSourceLocal, "Where to scrape Prometheus metrics from: \"local\" (sidecar/proxy at localhost:8080), \"apiserver\" (the apiserver's own /metrics, authenticated via the resolved kubeconfig), or \"url\" (an arbitrary --metrics-url).")
+	flag.StringVar(&metricsURL, "metrics-url", "", "Arbitrary metrics URL to scrape. Only used when --metrics-source=url.")
+	flag.StringVar(&metricName, "metric-name", defaultMetricName, fmt.Sprintf("Name of the Prometheus metric to quantify. Falls back to %q when not present in the scrape (pre-1.14 apiservers).", legacyMetricName))
+	flag.StringVar(&storePath, "store-path", "apiserver_req_quantifier.db", "Path to the bbolt database used to persist historical snapshots for windowed rate queries.")
+	flag.DurationVar(&scrapeInterval, "scrape-interval", 30*time.Second, "How often to scrape and persist a metrics snapshot for windowed rate queries. Set to 0 to disable historical storage.")
+	flag.Parse()
+
+	if len(kubeconfigPath) == 0 {
+		kubeconfigPath = os.Getenv("KUBECONFIG")
 	}
-	objBegin := strings.Split(objEnd[0], "{")
-	if len(objBegin) < 2 {
-		return nil, fmt.Errorf("missing metrics object delimiter '{'")
+
+	restConfig, err := loadRESTConfig(kubeconfigPath)
+	if err != nil {
+		panic(err)
 	}
-	fullObjFields := strings.Split(objBegin[1], ",")
 
-	for _, field := range fullObjFields {
-		segs := strings.Split(field, "=")
-		if len(segs) < 2 {
-			continue
-		}
-		key := segs[0]
-		val := segs[1]
-		switch key {
-		case "client":
-			req.clientName = val
-		case "resource":
-			req.resources = []string{val}
-		case "verb":
-			req.verbs = []string{val}
-		}
+	if metricsSource == metricsSourceURL && len(metricsURL) == 0 {
+		panic("--metrics-url must be set when --metrics-source=url")
 	}
 
-	return req, nil
-}
+	handler := &quantReqHandler{
+		restConfig:    restConfig,
+		metricsSource: metricsSource,
+		metricsURL:    metricsURL,
+		metricName:    metricName,
+	}
 
-func main() {
-	handler := &quantReqHandler{}
+	if scrapeInterval > 0 {
+		store, err := openSnapshotStore(storePath)
+		if err != nil {
+			panic(err)
+		}
+		defer store.Close()
+		handler.store = store
 
-	flag.StringVar(&handler.kubeconfig, "kubeconfig", "", "Absolute path to the kubeconfig generated by the OpenShift installer")
-	if len(handler.kubeconfig) == 0 {
-		handler.kubeconfig = os.Getenv("KUBECONFIG")
+		stop := make(chan struct{})
+		defer close(stop)
+		go handler.runScraper(scrapeInterval, stop)
 	}
-	if len(handler.kubeconfig) == 0 {
-		panic("A --kubeconfig location must be specified.")
-	}
-
-	flag.Parse()
 
 	server := http.Server{
 		Addr:    fmt.Sprintf("%s:%s", quantAddr, quantPort),
-		Handler: handler,
+		Handler: handler.Router(),
 	}
 
 	fmt.Printf("Listening at %s on port %s...\n", quantAddr, quantPort)
-	fmt.Printf("Scraping Prometheus metrics at %s on port %s...\n", metricsAddr, metricsPort)
-	fmt.Printf("Using KUBECONFIG file: %s\n", handler.kubeconfig)
+	switch metricsSource {
+	case metricsSourceAPIServer:
+		fmt.Printf("Scraping Prometheus metrics directly from the apiserver at %s...\n", restConfig.Host)
+	case metricsSourceURL:
+		fmt.Printf("Scraping Prometheus metrics at %s...\n", metricsURL)
+	default:
+		fmt.Printf("Scraping Prometheus metrics at %s on port %s...\n", metricsAddr, metricsPort)
+	}
+	fmt.Printf("Using apiserver: %s\n", restConfig.Host)
+	if handler.store != nil {
+		fmt.Printf("Recording history to %s every %s for windowed rate queries...\n", storePath, scrapeInterval)
+	}
 
 	if err := server.ListenAndServe(); err != nil {
 		panic(err)
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var snapshotsBucket = []byte("snapshots")
+
+// storedReq is the JSON-serializable form of an ApiserverReq persisted to the
+// snapshot store, trimmed to just the fields worth keeping around for
+// historical rate computations.
+type storedReq struct {
+	Total     int64            `json:"total"`
+	Resources []string         `json:"resources"`
+	Verbs     []string         `json:"verbs"`
+	Codes     map[string]int64 `json:"codes"`
+}
+
+func toStoredReq(r *ApiserverReq) *storedReq {
+	return &storedReq{
+		Total:     r.TotalReqCount,
+		Resources: r.Resources,
+		Verbs:     r.Verbs,
+		Codes:     r.Codes,
+	}
+}
+
+// clientRate is the delta/rate of a client's requests over a window of
+// snapshots, returned by the windowed /clients and /top endpoints.
+type clientRate struct {
+	Client      string    `json:"client"`
+	WindowStart time.Time `json:"windowStart"`
+	WindowEnd   time.Time `json:"windowEnd"`
+	DeltaTotal  int64     `json:"deltaRequests"`
+	RatePerSec  float64   `json:"requestsPerSecond"`
+}
+
+// snapshotStore persists periodic quantify() snapshots to an embedded bbolt
+// database, keyed by (client, timestamp), so callers can later compute
+// request-rate deltas over an arbitrary window instead of only ever seeing
+// raw counter totals. Snapshots are recorded at client granularity only: the
+// per-client resources/verbs slices are kept for display, but deltas are not
+// sliceable by verb or resource the way the unwindowed listing is. Callers
+// that need a windowed breakdown by verb/resource must reject that
+// combination rather than silently ignoring the filter; see
+// validateWindowedOptions in api.go.
+type snapshotStore struct {
+	db *bolt.DB
+}
+
+// openSnapshotStore opens (creating if necessary) a bbolt database at path
+// for storing periodic quantify() snapshots.
+func openSnapshotStore(path string) (*snapshotStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open snapshot store %q: %v", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(snapshotsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialize snapshot store %q: %v", path, err)
+	}
+
+	return &snapshotStore{db: db}, nil
+}
+
+func (s *snapshotStore) Close() error {
+	return s.db.Close()
+}
+
+// record persists one quantify() snapshot, one entry per client, under ts.
+func (s *snapshotStore) record(ts time.Time, data ApiserverReqList) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(snapshotsBucket)
+		for _, req := range data {
+			clientBucket, err := root.CreateBucketIfNotExists([]byte(req.ClientName))
+			if err != nil {
+				return err
+			}
+			payload, err := json.Marshal(toStoredReq(req))
+			if err != nil {
+				return err
+			}
+			if err := clientBucket.Put(timestampKey(ts), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// rates computes, for every client with at least two snapshots at or after
+// since, the delta and per-second rate of requests between its earliest
+// snapshot in the window and its most recent snapshot overall.
+func (s *snapshotStore) rates(since time.Time) ([]*clientRate, error) {
+	rates := []*clientRate{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(snapshotsBucket)
+		return root.ForEach(func(name, _ []byte) error {
+			clientBucket := root.Bucket(name)
+			if clientBucket == nil {
+				return nil
+			}
+
+			c := clientBucket.Cursor()
+			firstKey, firstVal := c.Seek(timestampKey(since))
+			if firstKey == nil {
+				return nil
+			}
+			lastKey, lastVal := c.Last()
+			if string(lastKey) == string(firstKey) {
+				return nil
+			}
+
+			var first, last storedReq
+			if err := json.Unmarshal(firstVal, &first); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(lastVal, &last); err != nil {
+				return err
+			}
+
+			windowStart := time.Unix(0, timestampFromKey(firstKey))
+			windowEnd := time.Unix(0, timestampFromKey(lastKey))
+			delta := last.Total - first.Total
+			rates = append(rates, &clientRate{
+				Client:      string(name),
+				WindowStart: windowStart,
+				WindowEnd:   windowEnd,
+				DeltaTotal:  delta,
+				RatePerSec:  float64(delta) / windowEnd.Sub(windowStart).Seconds(),
+			})
+			return nil
+		})
+	})
+
+	return rates, err
+}
+
+func timestampKey(ts time.Time) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(ts.UnixNano()))
+	return buf
+}
+
+func timestampFromKey(key []byte) int64 {
+	return int64(binary.BigEndian.Uint64(key))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestStore(t *testing.T) *snapshotStore {
+	t.Helper()
+	store, err := openSnapshotStore(filepath.Join(t.TempDir(), "snapshots.db"))
+	if err != nil {
+		t.Fatalf("openSnapshotStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSnapshotStoreRatesComputesDeltaOverWindow(t *testing.T) {
+	store := openTestStore(t)
+
+	base := time.Unix(1700000000, 0)
+	if err := store.record(base, ApiserverReqList{{ClientName: "alpha", TotalReqCount: 100}}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := store.record(base.Add(10*time.Second), ApiserverReqList{{ClientName: "alpha", TotalReqCount: 150}}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	rates, err := store.rates(base)
+	if err != nil {
+		t.Fatalf("rates: %v", err)
+	}
+	if len(rates) != 1 {
+		t.Fatalf("len(rates) = %d, want 1", len(rates))
+	}
+
+	rate := rates[0]
+	if rate.Client != "alpha" {
+		t.Errorf("rate.Client = %q, want alpha", rate.Client)
+	}
+	if rate.DeltaTotal != 50 {
+		t.Errorf("rate.DeltaTotal = %d, want 50", rate.DeltaTotal)
+	}
+	if rate.RatePerSec != 5 {
+		t.Errorf("rate.RatePerSec = %v, want 5", rate.RatePerSec)
+	}
+}
+
+func TestSnapshotStoreRatesSkipsClientsWithOneSampleInWindow(t *testing.T) {
+	store := openTestStore(t)
+
+	base := time.Unix(1700000000, 0)
+	if err := store.record(base, ApiserverReqList{{ClientName: "alpha", TotalReqCount: 100}}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	rates, err := store.rates(base)
+	if err != nil {
+		t.Fatalf("rates: %v", err)
+	}
+	if len(rates) != 0 {
+		t.Errorf("rates = %+v, want none for a client with only one sample in the window", rates)
+	}
+}
+
+func TestSnapshotStoreRatesIgnoresSamplesBeforeSince(t *testing.T) {
+	store := openTestStore(t)
+
+	base := time.Unix(1700000000, 0)
+	if err := store.record(base, ApiserverReqList{{ClientName: "alpha", TotalReqCount: 100}}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	if err := store.record(base.Add(10*time.Second), ApiserverReqList{{ClientName: "alpha", TotalReqCount: 150}}); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	rates, err := store.rates(base.Add(20 * time.Second))
+	if err != nil {
+		t.Fatalf("rates: %v", err)
+	}
+	if len(rates) != 0 {
+		t.Errorf("rates = %+v, want none when since is after every sample", rates)
+	}
+}